@@ -0,0 +1,66 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"istio.io/istio/pkg/config"
+)
+
+// ErrConflict is returned by Update, UpdateStatus, Patch, and Delete when the caller's
+// ResourceVersion precondition no longer matches what's stored. It wraps the standard
+// apimachinery Conflict error so callers can retry-on-conflict with errors.IsConflict, the same
+// way they would against an API-server-backed ConfigStore.
+type ErrConflict struct {
+	*errors.StatusError
+}
+
+// newErrConflict builds an ErrConflict reporting that the stored resourceVersion for
+// kind/namespace/name no longer matches the caller's expectation.
+func newErrConflict(kind config.GroupVersionKind, namespace, name, current string) error {
+	gr := schema.GroupResource{Group: kind.Group, Resource: strings.ToLower(kind.Kind)}
+	return &ErrConflict{
+		StatusError: errors.NewConflict(gr, name, fmt.Errorf(
+			"object %s/%s has been modified; please apply your changes to the latest version and try again (current resourceVersion %q)",
+			namespace, name, current)),
+	}
+}
+
+// checkResourceVersion returns an ErrConflict if resourceVersion is non-empty and does not match
+// the currently stored resourceVersion for kind/namespace/name. An empty resourceVersion skips
+// the check, matching the unconditional-write convention callers already rely on. The current
+// value is read through Controller.Get, not configStore.Get directly, so the check observes any
+// live WithMutationCache overlay the same way every other reader does; otherwise a rapid
+// Update->Update (or Update->Delete) could check a precondition against state the write-through
+// cache has already superseded.
+func (c *Controller) checkResourceVersion(kind config.GroupVersionKind, namespace, name, resourceVersion string) error {
+	if resourceVersion == "" {
+		return nil
+	}
+	current := c.Get(kind, name, namespace)
+	if current == nil {
+		// Let the underlying configStore surface its own not-found error.
+		return nil
+	}
+	if current.ResourceVersion != resourceVersion {
+		return newErrConflict(kind, namespace, name, current.ResourceVersion)
+	}
+	return nil
+}
@@ -16,14 +16,19 @@ package memory
 
 import (
 	"fmt"
+	"time"
 
 	"k8s.io/apimachinery/pkg/types"
 
 	"istio.io/istio/pilot/pkg/model"
 	"istio.io/istio/pkg/config"
 	"istio.io/istio/pkg/config/schema/collection"
+	"istio.io/istio/pkg/util/sets"
 )
 
+// revisionLabel is the label used to pin a config to a particular control plane revision.
+const revisionLabel = "istio.io/rev"
+
 // Controller is an implementation of ConfigStoreController.
 type Controller struct {
 	monitor     Monitor
@@ -32,29 +37,130 @@ type Controller struct {
 
 	// If meshConfig.DiscoverySelectors are specified, the namespacesFilter tracks the namespaces this controller watches.
 	namespacesFilter func(obj interface{}) bool
+
+	// If a revision is specified, the revisionFilter tracks the revision this controller watches,
+	// so several in-memory controllers can share the same backing store without cross-talk.
+	revisionFilter func(obj interface{}) bool
+
+	// mutationCache, if enabled via WithMutationCache, overlays recent writes on top of
+	// configStore.Get/List until the Monitor has fully dispatched an event for the same object.
+	mutationCache *mutationCache
+}
+
+// ControllerOption configures optional Controller behavior.
+type ControllerOption func(*Controller)
+
+// WithMutationCache enables a write-through overlay so Get/List reflect a write immediately,
+// even while configStore itself hasn't synced the write or handlers haven't yet observed its
+// event. Overlaid entries are dropped once the Monitor acks the event, or after ttl, whichever
+// comes first. Acks are matched by object only, so an ack for an earlier event of an object also
+// drops the overlay of a later write to it.
+func WithMutationCache(ttl time.Duration) ControllerOption {
+	return func(c *Controller) {
+		cache := newMutationCache(ttl)
+		c.mutationCache = cache
+		c.monitor.SetAckHandler(func(ce ConfigEvent) {
+			cache.ack(keyOf(ce.config))
+		})
+	}
+}
+
+// Options configures the namespace and revision scope of a Controller.
+type Options struct {
+	// Namespaces, if non-empty, restricts the controller to configs in the given namespaces.
+	Namespaces []string
+	// Revision, if non-empty, restricts the controller to configs carrying a matching
+	// istio.io/rev label.
+	Revision string
+	// StrictRev requires an exact match between Revision and a config's istio.io/rev label.
+	// When false, a config with no revision label (or an unset Revision) is admitted as well,
+	// so a default control plane and its canaries can share a backend.
+	StrictRev bool
 }
 
 // NewController return an implementation of ConfigStoreController
 // This is a client-side monitor that dispatches events as the changes are being
 // made on the client.
-func NewController(cs model.ConfigStore) *Controller {
+func NewController(cs model.ConfigStore, opts ...ControllerOption) *Controller {
 	out := &Controller{
 		configStore: cs,
 		monitor:     NewMonitor(cs),
 	}
+	for _, opt := range opts {
+		opt(out)
+	}
+	return out
+}
+
+// NewControllerWithOptions returns an implementation of ConfigStoreController scoped to the
+// namespaces and revision given in opts, so multiple in-memory controllers can watch the same
+// backing store while each only observing its own slice of it.
+func NewControllerWithOptions(cs model.ConfigStore, opts Options, controllerOpts ...ControllerOption) *Controller {
+	out := NewController(cs, controllerOpts...)
+	if len(opts.Namespaces) > 0 {
+		out.namespacesFilter = namespaceFilter(opts.Namespaces)
+	}
+	if opts.Revision != "" || opts.StrictRev {
+		out.revisionFilter = revisionFilter(opts.Revision, opts.StrictRev)
+	}
 	return out
 }
 
 // NewSyncController return an implementation of model.ConfigStoreController which processes events synchronously
-func NewSyncController(cs model.ConfigStore) *Controller {
+func NewSyncController(cs model.ConfigStore, opts ...ControllerOption) *Controller {
 	out := &Controller{
 		configStore: cs,
 		monitor:     NewSyncMonitor(cs),
 	}
-
+	for _, opt := range opts {
+		opt(out)
+	}
 	return out
 }
 
+// namespaceFilter returns a predicate admitting a namespace string or a config.Config whose
+// namespace is one of namespaces.
+func namespaceFilter(namespaces []string) func(obj interface{}) bool {
+	allowed := sets.New(namespaces...)
+	return func(obj interface{}) bool {
+		switch o := obj.(type) {
+		case string:
+			return allowed.Contains(o)
+		case config.Config:
+			return allowed.Contains(o.Namespace)
+		default:
+			return true
+		}
+	}
+}
+
+// revisionFilter returns a predicate admitting a config.Config whose istio.io/rev label matches
+// rev. In non-strict mode a config (or rev) with no label set is admitted alongside an exact match.
+func revisionFilter(rev string, strict bool) func(obj interface{}) bool {
+	return func(obj interface{}) bool {
+		cfg, ok := obj.(config.Config)
+		if !ok {
+			return true
+		}
+		cfgRev := cfg.Labels[revisionLabel]
+		if strict {
+			return cfgRev == rev
+		}
+		return cfgRev == rev || cfgRev == "" || rev == ""
+	}
+}
+
+// inScope reports whether cfg is within this controller's namespace and revision scope.
+func (c *Controller) inScope(cfg config.Config) bool {
+	if c.namespacesFilter != nil && !c.namespacesFilter(cfg) {
+		return false
+	}
+	if c.revisionFilter != nil && !c.revisionFilter(cfg) {
+		return false
+	}
+	return true
+}
+
 func (c *Controller) RegisterHasSyncedHandler(cb func() bool) {
 	c.hasSynced = cb
 }
@@ -85,44 +191,81 @@ func (c *Controller) Get(kind config.GroupVersionKind, key, namespace string) *c
 	if c.namespacesFilter != nil && !c.namespacesFilter(namespace) {
 		return nil
 	}
-	return c.configStore.Get(kind, key, namespace)
+	var cfg *config.Config
+	if c.mutationCache != nil {
+		if overlay, ok := c.mutationCache.get(objectKey{kind: kind, namespace: namespace, name: key}); ok {
+			cfg = overlay
+		} else {
+			cfg = c.configStore.Get(kind, key, namespace)
+		}
+	} else {
+		cfg = c.configStore.Get(kind, key, namespace)
+	}
+	if cfg == nil || !c.inScope(*cfg) {
+		return nil
+	}
+	return cfg
 }
 
 func (c *Controller) Create(config config.Config) (revision string, err error) {
 	if revision, err = c.configStore.Create(config); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
-			config: config,
-			event:  model.EventAdd,
-		})
+		if c.mutationCache != nil {
+			c.mutationCache.record(keyOf(config), &config)
+		}
+		if c.inScope(config) {
+			c.monitor.ScheduleProcessEvent(ConfigEvent{
+				config: config,
+				event:  model.EventAdd,
+			})
+		}
 	}
 	return
 }
 
 func (c *Controller) Update(config config.Config) (newRevision string, err error) {
+	if err := c.checkResourceVersion(config.GroupVersionKind, config.Namespace, config.Name, config.ResourceVersion); err != nil {
+		return "", err
+	}
 	oldconfig := c.configStore.Get(config.GroupVersionKind, config.Name, config.Namespace)
 	if newRevision, err = c.configStore.Update(config); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
-			old:    *oldconfig,
-			config: config,
-			event:  model.EventUpdate,
-		})
+		if c.mutationCache != nil {
+			c.mutationCache.record(keyOf(config), &config)
+		}
+		if c.inScope(config) {
+			c.monitor.ScheduleProcessEvent(ConfigEvent{
+				old:    *oldconfig,
+				config: config,
+				event:  model.EventUpdate,
+			})
+		}
 	}
 	return
 }
 
 func (c *Controller) UpdateStatus(config config.Config) (newRevision string, err error) {
+	if err := c.checkResourceVersion(config.GroupVersionKind, config.Namespace, config.Name, config.ResourceVersion); err != nil {
+		return "", err
+	}
 	oldconfig := c.configStore.Get(config.GroupVersionKind, config.Name, config.Namespace)
 	if newRevision, err = c.configStore.UpdateStatus(config); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
-			old:    *oldconfig,
-			config: config,
-			event:  model.EventUpdate,
-		})
+		if c.mutationCache != nil {
+			c.mutationCache.record(keyOf(config), &config)
+		}
+		if c.inScope(config) {
+			c.monitor.ScheduleProcessEvent(ConfigEvent{
+				old:    *oldconfig,
+				config: config,
+				event:  model.EventUpdate,
+			})
+		}
 	}
 	return
 }
 
 func (c *Controller) Patch(orig config.Config, patchFn config.PatchFunc) (newRevision string, err error) {
+	if err := c.checkResourceVersion(orig.GroupVersionKind, orig.Namespace, orig.Name, orig.ResourceVersion); err != nil {
+		return "", err
+	}
 	cfg, typ := patchFn(orig.DeepCopy())
 	switch typ {
 	case types.MergePatchType:
@@ -131,24 +274,39 @@ func (c *Controller) Patch(orig config.Config, patchFn config.PatchFunc) (newRev
 		return "", fmt.Errorf("unsupported merge type: %s", typ)
 	}
 	if newRevision, err = c.configStore.Patch(cfg, patchFn); err == nil {
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
-			old:    orig,
-			config: cfg,
-			event:  model.EventUpdate,
-		})
+		if c.mutationCache != nil {
+			c.mutationCache.record(keyOf(cfg), &cfg)
+		}
+		if c.inScope(cfg) {
+			c.monitor.ScheduleProcessEvent(ConfigEvent{
+				old:    orig,
+				config: cfg,
+				event:  model.EventUpdate,
+			})
+		}
 	}
 	return
 }
 
 func (c *Controller) Delete(kind config.GroupVersionKind, key, namespace string, resourceVersion *string) error {
 	if config := c.Get(kind, key, namespace); config != nil {
+		if resourceVersion != nil {
+			if err := c.checkResourceVersion(kind, namespace, key, *resourceVersion); err != nil {
+				return err
+			}
+		}
 		if err := c.configStore.Delete(kind, key, namespace, resourceVersion); err != nil {
 			return err
 		}
-		c.monitor.ScheduleProcessEvent(ConfigEvent{
-			config: *config,
-			event:  model.EventDelete,
-		})
+		if c.mutationCache != nil {
+			c.mutationCache.record(objectKey{kind: kind, namespace: namespace, name: key}, nil)
+		}
+		if c.inScope(*config) {
+			c.monitor.ScheduleProcessEvent(ConfigEvent{
+				config: *config,
+				event:  model.EventDelete,
+			})
+		}
 		return nil
 	}
 	return fmt.Errorf("delete: config %v/%v/%v does not exist", kind, namespace, key)
@@ -156,14 +314,17 @@ func (c *Controller) Delete(kind config.GroupVersionKind, key, namespace string,
 
 func (c *Controller) List(kind config.GroupVersionKind, namespace string) []config.Config {
 	configs := c.configStore.List(kind, namespace)
-	if c.namespacesFilter != nil {
-		var out []config.Config
-		for _, config := range configs {
-			if c.namespacesFilter(config) {
-				out = append(out, config)
-			}
+	if c.mutationCache != nil {
+		configs = c.mutationCache.apply(kind, namespace, configs)
+	}
+	if c.namespacesFilter == nil && c.revisionFilter == nil {
+		return configs
+	}
+	var out []config.Config
+	for _, cfg := range configs {
+		if c.inScope(cfg) {
+			out = append(out, cfg)
 		}
-		return out
 	}
-	return configs
+	return out
 }
@@ -0,0 +1,114 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"istio.io/istio/pkg/config"
+)
+
+func TestController_UpdateRejectsStaleResourceVersion(t *testing.T) {
+	store := &fakeConfigStore{objs: []config.Config{newTestConfig("a", "1")}}
+	c := NewSyncController(store)
+
+	stale := newTestConfig("a", "0")
+	if _, err := c.Update(stale); err == nil || !errors.IsConflict(err) {
+		t.Fatalf("expected a conflict error for a stale resourceVersion, got %v", err)
+	}
+	if got := c.Get(testKind, "a", "ns"); got == nil || got.ResourceVersion != "1" {
+		t.Fatalf("expected the rejected update to leave the stored config unchanged, got %+v", got)
+	}
+
+	current := newTestConfig("a", "1")
+	current.ResourceVersion = "1"
+	if _, err := c.Update(current); err != nil {
+		t.Fatalf("expected a matching resourceVersion to be accepted, got %v", err)
+	}
+}
+
+func TestController_UpdateStatusRejectsStaleResourceVersion(t *testing.T) {
+	store := &fakeConfigStore{objs: []config.Config{newTestConfig("a", "1")}}
+	c := NewSyncController(store)
+
+	stale := newTestConfig("a", "0")
+	if _, err := c.UpdateStatus(stale); err == nil || !errors.IsConflict(err) {
+		t.Fatalf("expected a conflict error for a stale resourceVersion, got %v", err)
+	}
+}
+
+func TestController_PatchRejectsStaleResourceVersion(t *testing.T) {
+	store := &fakeConfigStore{objs: []config.Config{newTestConfig("a", "1")}}
+	c := NewSyncController(store)
+
+	orig := newTestConfig("a", "0")
+	patchFn := func(cfg config.Config) (config.Config, types.PatchType) {
+		cfg.ResourceVersion = "2"
+		return cfg, types.MergePatchType
+	}
+	if _, err := c.Patch(orig, patchFn); err == nil || !errors.IsConflict(err) {
+		t.Fatalf("expected a conflict error for a stale resourceVersion, got %v", err)
+	}
+}
+
+func TestController_DeleteRejectsStaleResourceVersion(t *testing.T) {
+	store := &fakeConfigStore{objs: []config.Config{newTestConfig("a", "1")}}
+	c := NewSyncController(store)
+
+	stale := "0"
+	if err := c.Delete(testKind, "a", "ns", &stale); err == nil || !errors.IsConflict(err) {
+		t.Fatalf("expected a conflict error for a stale resourceVersion, got %v", err)
+	}
+	if got := c.Get(testKind, "a", "ns"); got == nil {
+		t.Fatalf("expected the rejected delete to leave the config in place")
+	}
+}
+
+// laggingConfigStore simulates a backing store whose Update doesn't synchronously apply, so
+// Get keeps returning the pre-write value -- the scenario WithMutationCache exists to paper over
+// for readers, including checkResourceVersion's own read of the "current" resourceVersion.
+type laggingConfigStore struct {
+	fakeConfigStore
+}
+
+func (f *laggingConfigStore) Update(cfg config.Config) (string, error) {
+	return cfg.ResourceVersion, nil
+}
+
+func TestController_CheckResourceVersionUsesMutationCacheOverlay(t *testing.T) {
+	store := &laggingConfigStore{fakeConfigStore{objs: []config.Config{newTestConfig("a", "1")}}}
+	// NewController's Monitor only dispatches (and acks) events from Run, which is never started
+	// here, so the overlay recorded by the first Update is still live for the second one.
+	c := NewController(store, WithMutationCache(time.Minute))
+
+	// An empty resourceVersion makes the first Update unconditional.
+	first := newTestConfig("a", "")
+	if _, err := c.Update(first); err != nil {
+		t.Fatalf("unexpected error on first update: %v", err)
+	}
+
+	// store.Get still reports resourceVersion "1" (laggingConfigStore never applies the write),
+	// but the mutation cache overlay reports the first write's empty one. A second writer racing in against the
+	// now-superseded rv "1" must be rejected: if checkResourceVersion read configStore.Get
+	// directly instead of the overlay-aware Controller.Get, this would wrongly succeed.
+	second := newTestConfig("a", "1")
+	if _, err := c.Update(second); err == nil || !errors.IsConflict(err) {
+		t.Fatalf("expected a conflict error sourced from the mutation cache overlay, got %v", err)
+	}
+}
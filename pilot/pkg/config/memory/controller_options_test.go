@@ -0,0 +1,98 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+
+	"istio.io/istio/pkg/config"
+)
+
+func TestNewControllerWithOptions_NamespaceScoping(t *testing.T) {
+	store := &fakeConfigStore{}
+	foo := newTestConfig("a", "1")
+	foo.Namespace = "foo"
+	bar := newTestConfig("b", "1")
+	bar.Namespace = "bar"
+	store.objs = []config.Config{foo, bar}
+
+	c := NewControllerWithOptions(store, Options{Namespaces: []string{"foo"}})
+
+	if got := c.Get(testKind, "a", "foo"); got == nil {
+		t.Fatalf("expected to see a config in the allowed namespace")
+	}
+	if got := c.Get(testKind, "b", "bar"); got != nil {
+		t.Fatalf("expected the out-of-scope namespace to be hidden from Get, got %+v", got)
+	}
+
+	out := c.List(testKind, "")
+	if len(out) != 1 || out[0].Name != "a" {
+		t.Fatalf("expected List to only surface the allowed namespace, got %+v", out)
+	}
+}
+
+func TestNewControllerWithOptions_RevisionIsolation(t *testing.T) {
+	store := &fakeConfigStore{}
+	canary := newTestConfig("canary-cfg", "1")
+	canary.Labels = map[string]string{revisionLabel: "canary"}
+	stable := newTestConfig("stable-cfg", "1")
+	stable.Labels = map[string]string{revisionLabel: "stable"}
+	store.objs = []config.Config{canary, stable}
+
+	// Two controllers sharing one backing store, scoped to different revisions, must not
+	// cross-talk: each only ever sees its own revision's configs.
+	canaryController := NewControllerWithOptions(store, Options{Revision: "canary", StrictRev: true})
+	stableController := NewControllerWithOptions(store, Options{Revision: "stable", StrictRev: true})
+
+	canaryOut := canaryController.List(testKind, "ns")
+	if len(canaryOut) != 1 || canaryOut[0].Name != "canary-cfg" {
+		t.Fatalf("expected the canary controller to only see canary-labeled configs, got %+v", canaryOut)
+	}
+	if got := canaryController.Get(testKind, "stable-cfg", "ns"); got != nil {
+		t.Fatalf("expected the canary controller's Get to hide the stable revision, got %+v", got)
+	}
+
+	stableOut := stableController.List(testKind, "ns")
+	if len(stableOut) != 1 || stableOut[0].Name != "stable-cfg" {
+		t.Fatalf("expected the stable controller to only see stable-labeled configs, got %+v", stableOut)
+	}
+	if got := stableController.Get(testKind, "canary-cfg", "ns"); got != nil {
+		t.Fatalf("expected the stable controller's Get to hide the canary revision, got %+v", got)
+	}
+}
+
+func TestNewControllerWithOptions_NonStrictRevisionAdmitsUnlabeled(t *testing.T) {
+	store := &fakeConfigStore{}
+	labeled := newTestConfig("canary-cfg", "1")
+	labeled.Labels = map[string]string{revisionLabel: "canary"}
+	otherLabeled := newTestConfig("other-cfg", "1")
+	otherLabeled.Labels = map[string]string{revisionLabel: "other"}
+	unlabeled := newTestConfig("default-cfg", "1")
+	store.objs = []config.Config{labeled, otherLabeled, unlabeled}
+
+	c := NewControllerWithOptions(store, Options{Revision: "canary", StrictRev: false})
+
+	out := c.List(testKind, "ns")
+	names := map[string]bool{}
+	for _, cfg := range out {
+		names[cfg.Name] = true
+	}
+	if !names["canary-cfg"] || !names["default-cfg"] {
+		t.Fatalf("expected non-strict mode to admit both the matching revision and unlabeled configs, got %+v", out)
+	}
+	if names["other-cfg"] {
+		t.Fatalf("expected non-strict mode to still exclude a differently-labeled revision, got %+v", out)
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config"
+)
+
+// watchBufferSize bounds the number of undelivered events a single Watch subscriber holds before
+// the oldest ones are dropped in favor of an EventBookmark.
+const watchBufferSize = 100
+
+// ListOptions carries the label and field selectors, and paging, for ListWithOptions and Watch.
+// FieldSelector currently supports the well-known fields metadata.name and metadata.namespace.
+type ListOptions struct {
+	LabelSelector string
+	FieldSelector string
+	// Limit caps the number of results ListWithOptions returns; 0 means unlimited.
+	Limit int64
+	// Continue resumes a previous ListWithOptions call from the token it returned.
+	Continue string
+}
+
+// CancelFunc stops a Watch subscription. Calling it more than once is a no-op.
+type CancelFunc func()
+
+// EventBookmark is delivered on a Watch channel in place of events a subscriber's ring buffer had
+// to drop because it fell behind, signaling the receiver to re-List before trusting the stream.
+var EventBookmark = ConfigEvent{Bookmark: true}
+
+// selectorsFor parses opts' label and field selectors.
+func selectorsFor(opts ListOptions) (labels.Selector, fields.Selector, error) {
+	var labelSelector labels.Selector
+	if opts.LabelSelector != "" {
+		var err error
+		labelSelector, err = labels.Parse(opts.LabelSelector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid label selector %q: %v", opts.LabelSelector, err)
+		}
+	}
+	var fieldSelector fields.Selector
+	if opts.FieldSelector != "" {
+		var err error
+		fieldSelector, err = fields.ParseSelector(opts.FieldSelector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid field selector %q: %v", opts.FieldSelector, err)
+		}
+	}
+	return labelSelector, fieldSelector, nil
+}
+
+// fieldsOf exposes the well-known fields ListOptions.FieldSelector can match against.
+func fieldsOf(cfg config.Config) fields.Set {
+	return fields.Set{
+		"metadata.name":      cfg.Name,
+		"metadata.namespace": cfg.Namespace,
+	}
+}
+
+// matches reports whether cfg passes both selectors; a nil selector always matches.
+func matches(cfg config.Config, labelSelector labels.Selector, fieldSelector fields.Selector) bool {
+	if labelSelector != nil && !labelSelector.Matches(labels.Set(cfg.Labels)) {
+		return false
+	}
+	if fieldSelector != nil && !fieldSelector.Matches(fieldsOf(cfg)) {
+		return false
+	}
+	return true
+}
+
+// continueKey is the opaque, sortable token ListWithOptions pages on.
+func continueKey(cfg config.Config) string {
+	return cfg.Namespace + "/" + cfg.Name
+}
+
+// ListWithOptions lists configs of kind in namespace (all namespaces if empty, mirroring List),
+// narrowed by opts' label/field selectors, and paged by Limit/Continue. It returns the next
+// Continue token, empty once the last page has been returned.
+func (c *Controller) ListWithOptions(kind config.GroupVersionKind, namespace string, opts ListOptions) ([]config.Config, string, error) {
+	labelSelector, fieldSelector, err := selectorsFor(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	all := c.List(kind, namespace)
+	sort.Slice(all, func(i, j int) bool { return continueKey(all[i]) < continueKey(all[j]) })
+
+	matched := make([]config.Config, 0, len(all))
+	for _, cfg := range all {
+		if matches(cfg, labelSelector, fieldSelector) {
+			matched = append(matched, cfg)
+		}
+	}
+
+	if opts.Continue != "" {
+		start := sort.Search(len(matched), func(i int) bool { return continueKey(matched[i]) > opts.Continue })
+		matched = matched[start:]
+	}
+
+	if opts.Limit <= 0 || int64(len(matched)) <= opts.Limit {
+		return matched, "", nil
+	}
+	page := matched[:opts.Limit]
+	return page, continueKey(page[len(page)-1]), nil
+}
+
+// watcher is a single Watch subscription: a bounded, drop-oldest ring buffer fed by a handler
+// registered through AppendEventHandler, drained into out by run.
+type watcher struct {
+	mu         sync.Mutex
+	buf        []ConfigEvent
+	head       int
+	n          int
+	overflowed bool
+
+	notify chan struct{}
+	out    chan ConfigEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		buf:    make([]ConfigEvent, watchBufferSize),
+		notify: make(chan struct{}, 1),
+		out:    make(chan ConfigEvent),
+		done:   make(chan struct{}),
+	}
+}
+
+// push enqueues ce, dropping the oldest buffered event and recording an overflow if the ring
+// buffer is already full.
+func (w *watcher) push(ce ConfigEvent) {
+	w.mu.Lock()
+	select {
+	case <-w.done:
+		w.mu.Unlock()
+		return
+	default:
+	}
+	if w.n == len(w.buf) {
+		w.head = (w.head + 1) % len(w.buf)
+		w.n--
+		w.overflowed = true
+	}
+	w.buf[(w.head+w.n)%len(w.buf)] = ce
+	w.n++
+	w.mu.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop dequeues the oldest buffered event, if any, along with whether older events were dropped
+// ahead of it.
+func (w *watcher) pop() (ce ConfigEvent, overflowed, ok bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.n == 0 {
+		return ConfigEvent{}, false, false
+	}
+	ce = w.buf[w.head]
+	overflowed = w.overflowed
+	w.overflowed = false
+	w.head = (w.head + 1) % len(w.buf)
+	w.n--
+	return ce, overflowed, true
+}
+
+func (w *watcher) cancel() {
+	w.once.Do(func() { close(w.done) })
+}
+
+func (w *watcher) run() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.notify:
+		}
+		for {
+			ce, overflowed, ok := w.pop()
+			if !ok {
+				break
+			}
+			if overflowed {
+				select {
+				case w.out <- EventBookmark:
+				case <-w.done:
+					return
+				}
+			}
+			select {
+			case w.out <- ce:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+// Watch streams ConfigEvents of kind in namespace that match opts' label/field selectors. Events
+// are buffered per-subscriber in a bounded, drop-oldest ring; on overflow a synthetic
+// EventBookmark is delivered before the stream resumes, telling the receiver to re-List. The
+// returned CancelFunc stops delivery; the underlying event handler, once registered, is never
+// unregistered, matching RegisterEventHandler's existing lifetime.
+func (c *Controller) Watch(kind config.GroupVersionKind, namespace string, opts ListOptions) (<-chan ConfigEvent, CancelFunc, error) {
+	labelSelector, fieldSelector, err := selectorsFor(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w := newWatcher()
+	c.RegisterEventHandler(kind, func(old, newCfg config.Config, ev model.Event) {
+		if namespace != "" && newCfg.Namespace != namespace {
+			return
+		}
+		if !matches(newCfg, labelSelector, fieldSelector) {
+			return
+		}
+		w.push(ConfigEvent{old: old, config: newCfg, event: ev})
+	})
+	go w.run()
+
+	return w.out, w.cancel, nil
+}
@@ -0,0 +1,202 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"fmt"
+	"testing"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config"
+	"istio.io/istio/pkg/config/schema/collection"
+)
+
+// fakeConfigStore is a minimal in-memory model.ConfigStore backing ListWithOptions tests.
+type fakeConfigStore struct {
+	objs []config.Config
+}
+
+func (f *fakeConfigStore) Schemas() collection.Schemas { return collection.Schemas{} }
+
+func (f *fakeConfigStore) Get(kind config.GroupVersionKind, name, namespace string) *config.Config {
+	for i := range f.objs {
+		if f.objs[i].GroupVersionKind == kind && f.objs[i].Name == name && f.objs[i].Namespace == namespace {
+			out := f.objs[i]
+			return &out
+		}
+	}
+	return nil
+}
+
+func (f *fakeConfigStore) List(kind config.GroupVersionKind, namespace string) []config.Config {
+	var out []config.Config
+	for _, o := range f.objs {
+		if o.GroupVersionKind != kind {
+			continue
+		}
+		if namespace != "" && o.Namespace != namespace {
+			continue
+		}
+		out = append(out, o)
+	}
+	return out
+}
+
+func (f *fakeConfigStore) Create(cfg config.Config) (string, error) {
+	f.objs = append(f.objs, cfg)
+	return cfg.ResourceVersion, nil
+}
+
+func (f *fakeConfigStore) Update(cfg config.Config) (string, error) {
+	for i := range f.objs {
+		if f.objs[i].GroupVersionKind == cfg.GroupVersionKind && f.objs[i].Name == cfg.Name && f.objs[i].Namespace == cfg.Namespace {
+			f.objs[i] = cfg
+			return cfg.ResourceVersion, nil
+		}
+	}
+	f.objs = append(f.objs, cfg)
+	return cfg.ResourceVersion, nil
+}
+
+func (f *fakeConfigStore) UpdateStatus(cfg config.Config) (string, error) { return f.Update(cfg) }
+
+func (f *fakeConfigStore) Patch(cfg config.Config, _ config.PatchFunc) (string, error) {
+	return f.Update(cfg)
+}
+
+func (f *fakeConfigStore) Delete(kind config.GroupVersionKind, name, namespace string, _ *string) error {
+	for i := range f.objs {
+		if f.objs[i].GroupVersionKind == kind && f.objs[i].Name == name && f.objs[i].Namespace == namespace {
+			f.objs = append(f.objs[:i], f.objs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+func TestWatcherRingBuffer_DropsOldestAndReportsOverflow(t *testing.T) {
+	w := newWatcher()
+	total := watchBufferSize + 5
+	for i := 0; i < total; i++ {
+		w.push(ConfigEvent{config: newTestConfig(fmt.Sprintf("cfg-%d", i), "1"), event: model.EventAdd})
+	}
+
+	first, overflowed, ok := w.pop()
+	if !ok {
+		t.Fatalf("expected a buffered event")
+	}
+	if !overflowed {
+		t.Fatalf("expected the first pop after overflow to report dropped events")
+	}
+	if first.config.Name != fmt.Sprintf("cfg-%d", 5) {
+		t.Fatalf("expected the oldest surviving event to be cfg-5, got %s", first.config.Name)
+	}
+
+	_, overflowed2, ok2 := w.pop()
+	if !ok2 {
+		t.Fatalf("expected another buffered event")
+	}
+	if overflowed2 {
+		t.Fatalf("expected the overflow flag to clear after being reported once")
+	}
+}
+
+func TestWatcherRun_DeliversBookmarkOnOverflow(t *testing.T) {
+	w := newWatcher()
+	defer w.cancel()
+
+	for i := 0; i < watchBufferSize+2; i++ {
+		w.push(ConfigEvent{config: newTestConfig("x", "1"), event: model.EventAdd})
+	}
+	// Start run only once the buffer has overflowed, so it cannot pop and deliver an event first.
+	go w.run()
+
+	first := <-w.out
+	if !first.Bookmark {
+		t.Fatalf("expected the first delivered event to be EventBookmark after overflow, got %+v", first)
+	}
+
+	second := <-w.out
+	if second.Bookmark {
+		t.Fatalf("expected the event following the bookmark to be a real event, got %+v", second)
+	}
+}
+
+func TestListWithOptions_PagesAcrossContinueTokens(t *testing.T) {
+	store := &fakeConfigStore{}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		store.objs = append(store.objs, newTestConfig(name, "1"))
+	}
+	c := NewSyncController(store)
+
+	page1, cont1, err := c.ListWithOptions(testKind, "ns", ListOptions{Limit: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page1) != 2 || page1[0].Name != "a" || page1[1].Name != "b" {
+		t.Fatalf("unexpected first page: %+v", page1)
+	}
+	if cont1 == "" {
+		t.Fatalf("expected a continue token for a partial page")
+	}
+
+	page2, cont2, err := c.ListWithOptions(testKind, "ns", ListOptions{Limit: 2, Continue: cont1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page2) != 2 || page2[0].Name != "c" || page2[1].Name != "d" {
+		t.Fatalf("unexpected second page (off-by-one around the page boundary): %+v", page2)
+	}
+	if cont2 == "" {
+		t.Fatalf("expected a continue token for a second partial page")
+	}
+
+	page3, cont3, err := c.ListWithOptions(testKind, "ns", ListOptions{Limit: 2, Continue: cont2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(page3) != 1 || page3[0].Name != "e" {
+		t.Fatalf("unexpected final page: %+v", page3)
+	}
+	if cont3 != "" {
+		t.Fatalf("expected no continue token after the last page, got %q", cont3)
+	}
+}
+
+func TestListWithOptions_LabelAndFieldSelector(t *testing.T) {
+	store := &fakeConfigStore{}
+	a := newTestConfig("a", "1")
+	a.Labels = map[string]string{"env": "prod"}
+	b := newTestConfig("b", "1")
+	b.Labels = map[string]string{"env": "dev"}
+	store.objs = []config.Config{a, b}
+	c := NewSyncController(store)
+
+	out, _, err := c.ListWithOptions(testKind, "ns", ListOptions{LabelSelector: "env=prod"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) != 1 || out[0].Name != "a" {
+		t.Fatalf("expected only the prod-labeled config, got %+v", out)
+	}
+
+	out, _, err = c.ListWithOptions(testKind, "ns", ListOptions{FieldSelector: "metadata.name=b"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(out) != 1 || out[0].Name != "b" {
+		t.Fatalf("expected only metadata.name=b, got %+v", out)
+	}
+}
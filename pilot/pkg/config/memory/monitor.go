@@ -0,0 +1,242 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config"
+)
+
+// ConfigEvent bundles a config change together with the event type that produced it, as
+// dispatched to handlers registered on a Controller.
+type ConfigEvent struct {
+	config config.Config
+	old    config.Config
+	event  model.Event
+
+	// Bookmark is set on a synthetic ConfigEvent sent in place of events a Watch subscriber's
+	// ring buffer had to drop; see EventBookmark.
+	Bookmark bool
+}
+
+// Monitor schedules ConfigEvents produced by a Controller and dispatches them to handlers
+// registered for the event's kind.
+type Monitor interface {
+	Run(stop <-chan struct{})
+	AppendEventHandler(kind config.GroupVersionKind, f model.EventHandler)
+	// ScheduleProcessEvent queues configEvent for dispatch. Depending on the Monitor, this may
+	// be coalesced with other pending events for the same object before handlers observe it.
+	ScheduleProcessEvent(configEvent ConfigEvent)
+	// QueueDepth returns the number of distinct objects with an undispatched event pending.
+	QueueDepth() int
+	// SetAckHandler registers a callback invoked once a scheduled ConfigEvent has been fully
+	// dispatched to all of its handlers, so callers can expire state keyed on that event.
+	SetAckHandler(f func(ConfigEvent))
+}
+
+// objectKey identifies the (kind, namespace, name) an event applies to, which is the granularity
+// at which pending deltas are coalesced.
+type objectKey struct {
+	kind      config.GroupVersionKind
+	namespace string
+	name      string
+}
+
+func keyOf(cfg config.Config) objectKey {
+	return objectKey{kind: cfg.GroupVersionKind, namespace: cfg.Namespace, name: cfg.Name}
+}
+
+// configStoreMonitor dispatches ConfigEvents to registered handlers. In synchronous mode events
+// are dispatched inline with ScheduleProcessEvent; otherwise they are coalesced per-object,
+// DeltaFIFO-style, and drained by a single worker so handlers never see more events than the net
+// effect of what actually happened to an object.
+type configStoreMonitor struct {
+	store  model.ConfigStore
+	syncCh bool // true for NewSyncMonitor: dispatch inline, no coalescing
+
+	mu       sync.Mutex
+	handlers map[config.GroupVersionKind][]model.EventHandler
+
+	pending map[objectKey]ConfigEvent // coalesced event per key with an undispatched delta
+	order   []objectKey               // FIFO of keys in pending, so the oldest touched key drains first
+	queued  map[objectKey]bool        // membership test for order, so a key is queued at most once
+
+	notify chan struct{}
+
+	ackHandler func(ConfigEvent)
+}
+
+// NewMonitor returns a Monitor that coalesces events per-object and dispatches them to handlers
+// from a single background worker started by Run.
+func NewMonitor(store model.ConfigStore) Monitor {
+	return newConfigStoreMonitor(store, false)
+}
+
+// NewSyncMonitor returns a Monitor that dispatches events to handlers inline with
+// ScheduleProcessEvent, for callers that need deterministic, synchronous delivery.
+func NewSyncMonitor(store model.ConfigStore) Monitor {
+	return newConfigStoreMonitor(store, true)
+}
+
+func newConfigStoreMonitor(store model.ConfigStore, sync bool) *configStoreMonitor {
+	return &configStoreMonitor{
+		store:    store,
+		syncCh:   sync,
+		handlers: make(map[config.GroupVersionKind][]model.EventHandler),
+		pending:  make(map[objectKey]ConfigEvent),
+		queued:   make(map[objectKey]bool),
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (m *configStoreMonitor) AppendEventHandler(kind config.GroupVersionKind, f model.EventHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers[kind] = append(m.handlers[kind], f)
+}
+
+func (m *configStoreMonitor) ScheduleProcessEvent(configEvent ConfigEvent) {
+	if m.syncCh {
+		m.dispatch(configEvent)
+		return
+	}
+
+	m.mu.Lock()
+	key := keyOf(configEvent.config)
+	if prev, ok := m.pending[key]; ok {
+		merged, keep := coalesce(prev, configEvent)
+		if !keep {
+			delete(m.pending, key)
+			m.unqueue(key)
+			m.mu.Unlock()
+			return
+		}
+		m.pending[key] = merged
+	} else {
+		m.pending[key] = configEvent
+	}
+	if !m.queued[key] {
+		m.queued[key] = true
+		m.order = append(m.order, key)
+	}
+	m.mu.Unlock()
+
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+}
+
+// coalesce folds next on top of prev, the still-undispatched delta for the same object, following
+// the compression rules of a client-go style DeltaFIFO: Add+Update*->Add (latest spec),
+// Update+Update->Update (original old, latest config), Add+Delete->drop the key entirely,
+// Update+Delete->Delete (original old), Delete+Add->Update. keep is false when the net effect is
+// that handlers should see nothing at all for this object.
+func coalesce(prev, next ConfigEvent) (merged ConfigEvent, keep bool) {
+	switch {
+	case prev.event == model.EventAdd && next.event == model.EventDelete:
+		return ConfigEvent{}, false
+	case prev.event == model.EventAdd && next.event == model.EventUpdate:
+		return ConfigEvent{config: next.config, event: model.EventAdd}, true
+	case prev.event == model.EventUpdate && next.event == model.EventUpdate:
+		return ConfigEvent{old: prev.old, config: next.config, event: model.EventUpdate}, true
+	case prev.event == model.EventUpdate && next.event == model.EventDelete:
+		return ConfigEvent{old: prev.old, config: next.config, event: model.EventDelete}, true
+	case prev.event == model.EventDelete && next.event == model.EventAdd:
+		return ConfigEvent{old: prev.config, config: next.config, event: model.EventUpdate}, true
+	default:
+		// Same-event repeats (e.g. Add+Add) and combinations that shouldn't occur in practice
+		// fall back to taking the latest delta as-is.
+		return next, true
+	}
+}
+
+// unqueue removes key from order. Callers hold m.mu.
+func (m *configStoreMonitor) unqueue(key objectKey) {
+	if !m.queued[key] {
+		return
+	}
+	delete(m.queued, key)
+	for i, k := range m.order {
+		if k == key {
+			m.order = append(m.order[:i], m.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// QueueDepth returns the number of objects with a coalesced delta awaiting dispatch.
+func (m *configStoreMonitor) QueueDepth() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.order)
+}
+
+func (m *configStoreMonitor) Run(stop <-chan struct{}) {
+	if m.syncCh {
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-m.notify:
+			for m.processNext() {
+			}
+		}
+	}
+}
+
+// processNext pops the oldest queued key and dispatches its coalesced event, returning whether
+// there is more work immediately available.
+func (m *configStoreMonitor) processNext() bool {
+	m.mu.Lock()
+	if len(m.order) == 0 {
+		m.mu.Unlock()
+		return false
+	}
+	key := m.order[0]
+	m.order = m.order[1:]
+	delete(m.queued, key)
+	ce := m.pending[key]
+	delete(m.pending, key)
+	m.mu.Unlock()
+
+	m.dispatch(ce)
+	return true
+}
+
+func (m *configStoreMonitor) dispatch(ce ConfigEvent) {
+	m.mu.Lock()
+	handlers := append([]model.EventHandler(nil), m.handlers[ce.config.GroupVersionKind]...)
+	ack := m.ackHandler
+	m.mu.Unlock()
+	for _, f := range handlers {
+		f(ce.old, ce.config, ce.event)
+	}
+	if ack != nil {
+		ack(ce)
+	}
+}
+
+// SetAckHandler registers f to be called once a scheduled ConfigEvent has been dispatched to all
+// of its handlers (including a no-op dispatch with zero handlers registered for its kind).
+func (m *configStoreMonitor) SetAckHandler(f func(ConfigEvent)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ackHandler = f
+}
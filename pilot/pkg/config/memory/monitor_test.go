@@ -0,0 +1,198 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/istio/pilot/pkg/model"
+	"istio.io/istio/pkg/config"
+)
+
+var testKind = config.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "VirtualService"}
+
+func newTestConfig(name, resourceVersion string) config.Config {
+	cfg := config.Config{}
+	cfg.GroupVersionKind = testKind
+	cfg.Namespace = "ns"
+	cfg.Name = name
+	cfg.ResourceVersion = resourceVersion
+	return cfg
+}
+
+// waitFor polls cond until it returns true or fails the test after a short deadline.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("condition not met within timeout")
+}
+
+func TestCoalesce(t *testing.T) {
+	add := ConfigEvent{config: newTestConfig("a", "1"), event: model.EventAdd}
+	update1 := ConfigEvent{old: newTestConfig("a", "1"), config: newTestConfig("a", "2"), event: model.EventUpdate}
+	update2 := ConfigEvent{old: newTestConfig("a", "2"), config: newTestConfig("a", "3"), event: model.EventUpdate}
+	del := ConfigEvent{config: newTestConfig("a", "2"), event: model.EventDelete}
+
+	t.Run("Add+Update collapses to Add with the latest spec", func(t *testing.T) {
+		merged, keep := coalesce(add, update1)
+		if !keep {
+			t.Fatalf("expected the merged event to be kept")
+		}
+		if merged.event != model.EventAdd {
+			t.Errorf("expected EventAdd, got %v", merged.event)
+		}
+		if merged.config.ResourceVersion != "2" {
+			t.Errorf("expected latest resourceVersion 2, got %s", merged.config.ResourceVersion)
+		}
+	})
+
+	t.Run("Update+Update keeps the original old and the latest config", func(t *testing.T) {
+		merged, keep := coalesce(update1, update2)
+		if !keep {
+			t.Fatalf("expected the merged event to be kept")
+		}
+		if merged.event != model.EventUpdate {
+			t.Errorf("expected EventUpdate, got %v", merged.event)
+		}
+		if merged.old.ResourceVersion != "1" {
+			t.Errorf("expected the original old resourceVersion 1, got %s", merged.old.ResourceVersion)
+		}
+		if merged.config.ResourceVersion != "3" {
+			t.Errorf("expected the latest resourceVersion 3, got %s", merged.config.ResourceVersion)
+		}
+	})
+
+	t.Run("Add+Delete drops the key entirely", func(t *testing.T) {
+		_, keep := coalesce(add, del)
+		if keep {
+			t.Errorf("expected Add+Delete to drop the pending event")
+		}
+	})
+
+	t.Run("Update+Delete collapses to Delete with the original old", func(t *testing.T) {
+		merged, keep := coalesce(update1, del)
+		if !keep {
+			t.Fatalf("expected the merged event to be kept")
+		}
+		if merged.event != model.EventDelete {
+			t.Errorf("expected EventDelete, got %v", merged.event)
+		}
+		if merged.old.ResourceVersion != "1" {
+			t.Errorf("expected the original old resourceVersion 1, got %s", merged.old.ResourceVersion)
+		}
+	})
+
+	t.Run("Delete+Add collapses to Update", func(t *testing.T) {
+		readd := ConfigEvent{config: newTestConfig("a", "4"), event: model.EventAdd}
+		merged, keep := coalesce(del, readd)
+		if !keep {
+			t.Fatalf("expected the merged event to be kept")
+		}
+		if merged.event != model.EventUpdate {
+			t.Errorf("expected EventUpdate, got %v", merged.event)
+		}
+		if merged.old.ResourceVersion != del.config.ResourceVersion {
+			t.Errorf("expected old to be the deleted config (rv %s), got rv %s", del.config.ResourceVersion, merged.old.ResourceVersion)
+		}
+		if merged.config.ResourceVersion != "4" {
+			t.Errorf("expected the latest resourceVersion 4, got %s", merged.config.ResourceVersion)
+		}
+	})
+}
+
+func TestScheduleProcessEvent_CoalescesRapidUpdates(t *testing.T) {
+	m := newConfigStoreMonitor(nil, false)
+
+	var mu sync.Mutex
+	var dispatched []ConfigEvent
+	m.AppendEventHandler(testKind, func(old, newCfg config.Config, ev model.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		dispatched = append(dispatched, ConfigEvent{old: old, config: newCfg, event: ev})
+	})
+
+	a := newTestConfig("a", "1")
+	b := newTestConfig("a", "2")
+	c := newTestConfig("a", "3")
+	m.ScheduleProcessEvent(ConfigEvent{config: a, event: model.EventAdd})
+	m.ScheduleProcessEvent(ConfigEvent{old: a, config: b, event: model.EventUpdate})
+	m.ScheduleProcessEvent(ConfigEvent{old: b, config: c, event: model.EventUpdate})
+
+	if depth := m.QueueDepth(); depth != 1 {
+		t.Fatalf("expected 1 coalesced key pending before Run starts, got %d", depth)
+	}
+
+	stop := make(chan struct{})
+	go m.Run(stop)
+	defer close(stop)
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(dispatched) == 1
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dispatched[0].event != model.EventAdd {
+		t.Errorf("expected the coalesced dispatch to remain an Add, got %v", dispatched[0].event)
+	}
+	if dispatched[0].config.ResourceVersion != "3" {
+		t.Errorf("expected the latest resourceVersion 3 to reach the handler, got %s", dispatched[0].config.ResourceVersion)
+	}
+}
+
+func TestMonitor_WorkerDrainsKeysInFIFOOrder(t *testing.T) {
+	m := newConfigStoreMonitor(nil, false)
+
+	var mu sync.Mutex
+	var order []string
+	m.AppendEventHandler(testKind, func(_, newCfg config.Config, _ model.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, newCfg.Name)
+	})
+
+	for _, name := range []string{"a", "b", "c"} {
+		m.ScheduleProcessEvent(ConfigEvent{config: newTestConfig(name, "1"), event: model.EventAdd})
+	}
+
+	stop := make(chan struct{})
+	go m.Run(stop)
+	defer close(stop)
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(order) == 3
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"a", "b", "c"}
+	for i, name := range want {
+		if order[i] != name {
+			t.Fatalf("expected dispatch order %v, got %v", want, order)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"istio.io/istio/pkg/config"
+)
+
+// mutationCacheEntry overlays the result of a write until it expires. A nil cfg is a tombstone:
+// the object was deleted and Get/List should treat it as gone even if configStore (briefly)
+// still reflects the pre-delete value.
+type mutationCacheEntry struct {
+	cfg     *config.Config
+	expires time.Time
+}
+
+// mutationCache is a small write-through overlay on top of a configStore, modeled on client-go's
+// mutation_cache.go: Create/Update/Patch/Delete record the write here immediately, so a Get/List
+// racing with asynchronous event dispatch observes the write right away instead of stale state.
+// Entries are removed once the Monitor acks an event for the same object, or after ttl lapses.
+type mutationCache struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	entries map[objectKey]mutationCacheEntry
+}
+
+func newMutationCache(ttl time.Duration) *mutationCache {
+	return &mutationCache{
+		ttl:     ttl,
+		entries: make(map[objectKey]mutationCacheEntry),
+	}
+}
+
+// record overlays cfg (nil for a tombstone) for key until it is acked or the ttl lapses.
+func (c *mutationCache) record(key objectKey, cfg *config.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = mutationCacheEntry{cfg: cfg, expires: time.Now().Add(c.ttl)}
+}
+
+// ack drops the overlay for key, called once its write's event has been fully dispatched.
+func (c *mutationCache) ack(key objectKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// get returns the overlay for key, if any live one remains. A nil, true result is a tombstone.
+func (c *mutationCache) get(key objectKey) (cfg *config.Config, ok bool) {
+	c.mu.RLock()
+	e, found := c.entries[key]
+	c.mu.RUnlock()
+	if !found {
+		return nil, false
+	}
+	if time.Now().After(e.expires) {
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.mu.Unlock()
+		return nil, false
+	}
+	return e.cfg, true
+}
+
+// apply overlays live entries scoped to kind/namespace on top of configs: replacing objects with
+// a newer write, dropping tombstoned ones, and appending overlaid objects configStore doesn't yet
+// reflect.
+func (c *mutationCache) apply(kind config.GroupVersionKind, namespace string, configs []config.Config) []config.Config {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.entries) == 0 {
+		return configs
+	}
+
+	now := time.Now()
+	overlay := make(map[objectKey]*config.Config)
+	for key, e := range c.entries {
+		if key.kind != kind || now.After(e.expires) {
+			continue
+		}
+		if namespace != "" && key.namespace != namespace {
+			continue
+		}
+		overlay[key] = e.cfg
+	}
+	if len(overlay) == 0 {
+		return configs
+	}
+
+	out := make([]config.Config, 0, len(configs))
+	seen := make(map[objectKey]bool, len(configs))
+	for _, cfg := range configs {
+		key := keyOf(cfg)
+		seen[key] = true
+		if replacement, overlaid := overlay[key]; overlaid {
+			if replacement != nil {
+				out = append(out, *replacement)
+			}
+			continue // tombstoned: drop from the result
+		}
+		out = append(out, cfg)
+	}
+	for key, cfg := range overlay {
+		if !seen[key] && cfg != nil {
+			out = append(out, *cfg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,109 @@
+// Copyright Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"istio.io/istio/pkg/config"
+)
+
+func TestMutationCache_RecordAndGet(t *testing.T) {
+	c := newMutationCache(time.Minute)
+	cfg := newTestConfig("a", "1")
+	key := keyOf(cfg)
+
+	if _, ok := c.get(key); ok {
+		t.Fatalf("expected no overlay before record")
+	}
+
+	c.record(key, &cfg)
+	got, ok := c.get(key)
+	if !ok || got == nil || got.ResourceVersion != "1" {
+		t.Fatalf("expected overlay to return the recorded config, got %+v ok=%v", got, ok)
+	}
+}
+
+func TestMutationCache_TombstoneThenAck(t *testing.T) {
+	c := newMutationCache(time.Minute)
+	key := objectKey{kind: testKind, namespace: "ns", name: "a"}
+
+	c.record(key, nil)
+	got, ok := c.get(key)
+	if !ok || got != nil {
+		t.Fatalf("expected a tombstone (nil, true), got %+v ok=%v", got, ok)
+	}
+
+	c.ack(key)
+	if _, ok := c.get(key); ok {
+		t.Fatalf("expected the overlay to be gone after ack")
+	}
+}
+
+func TestMutationCache_TTLExpiry(t *testing.T) {
+	c := newMutationCache(time.Millisecond)
+	cfg := newTestConfig("a", "1")
+	key := keyOf(cfg)
+	c.record(key, &cfg)
+
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get(key); ok {
+		t.Fatalf("expected the overlay to have expired")
+	}
+}
+
+func TestMutationCache_ApplyAllNamespaces(t *testing.T) {
+	c := newMutationCache(time.Minute)
+	cfg := newTestConfig("a", "1")
+	cfg.Namespace = "foo"
+	c.record(keyOf(cfg), &cfg)
+
+	out := c.apply(testKind, "", nil)
+	if len(out) != 1 || out[0].Namespace != "foo" || out[0].Name != "a" {
+		t.Fatalf("expected the foo-namespace overlay to surface on an all-namespaces List, got %+v", out)
+	}
+}
+
+func TestMutationCache_ApplyScopesToRequestedNamespace(t *testing.T) {
+	c := newMutationCache(time.Minute)
+	cfg := newTestConfig("a", "1")
+	cfg.Namespace = "foo"
+	c.record(keyOf(cfg), &cfg)
+
+	out := c.apply(testKind, "bar", nil)
+	if len(out) != 0 {
+		t.Fatalf("expected no overlay when the requested namespace doesn't match, got %+v", out)
+	}
+}
+
+func TestMutationCache_ApplyReplacesAndDropsTombstones(t *testing.T) {
+	c := newMutationCache(time.Minute)
+
+	existing := newTestConfig("a", "1")
+	updated := newTestConfig("a", "2")
+	c.record(keyOf(existing), &updated)
+
+	deleted := newTestConfig("b", "1")
+	c.record(keyOf(deleted), nil)
+
+	out := c.apply(testKind, "ns", []config.Config{existing, deleted})
+	if len(out) != 1 {
+		t.Fatalf("expected the tombstoned object to be dropped, got %+v", out)
+	}
+	if out[0].Name != "a" || out[0].ResourceVersion != "2" {
+		t.Fatalf("expected the live object to be overlaid with the latest write, got %+v", out[0])
+	}
+}